@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// bufferSpillThreshold is the in-memory ceiling for a buffered body before
+// it's spilled to a temp file, so a handful of large requests/responses
+// can't drive the process out of memory.
+const bufferSpillThreshold = 512 * 1024
+
+var errBodyTooLarge = errors.New("buffering: body exceeds configured limit")
+
+// modifyResponseError wraps an error returned from
+// httputil.ReverseProxy.ModifyResponse so its ErrorHandler can recognize a
+// failure in the buffering layer (response already received) and treat it
+// differently from a transport-level failure (response never received).
+type modifyResponseError struct{ err error }
+
+func (e *modifyResponseError) Error() string { return e.err.Error() }
+func (e *modifyResponseError) Unwrap() error { return e.err }
+
+// BufferingPolicy configures request/response buffering for a route, to
+// protect slow-client-intolerant upstreams (e.g. gunicorn) from having a
+// connection held open by a slow client, and to cap body sizes.
+type BufferingPolicy struct {
+	MaxRequestBodyBytes  int64         `yaml:"maxRequestBodyBytes"`
+	MaxResponseBodyBytes int64         `yaml:"maxResponseBodyBytes"`
+	FlushInterval        time.Duration `yaml:"flushInterval"`
+	BufferRequests       bool          `yaml:"bufferRequests"`
+	BufferResponses      bool          `yaml:"bufferResponses"`
+}
+
+// RouteTimeouts overrides the server-global ReadTimeout/WriteTimeout for a
+// single route, applied via http.ResponseController so routes can differ
+// without needing separate http.Server listeners.
+type RouteTimeouts struct {
+	ReadTimeout  time.Duration `yaml:"readTimeout"`
+	WriteTimeout time.Duration `yaml:"writeTimeout"`
+}
+
+// WrapRoute applies policy and timeouts to next: it fully buffers the
+// request body (rejecting with 413 past MaxRequestBodyBytes) when
+// BufferRequests is set, and overrides this connection's read/write
+// deadlines when the route's timeouts are configured.
+func WrapRoute(next http.Handler, policy BufferingPolicy, timeouts RouteTimeouts) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if timeouts.ReadTimeout > 0 {
+			if err := rc.SetReadDeadline(time.Now().Add(timeouts.ReadTimeout)); err != nil {
+				http.Error(w, "buffering: SetReadDeadline unsupported", http.StatusInternalServerError)
+				return
+			}
+		}
+		if timeouts.WriteTimeout > 0 {
+			if err := rc.SetWriteDeadline(time.Now().Add(timeouts.WriteTimeout)); err != nil {
+				http.Error(w, "buffering: SetWriteDeadline unsupported", http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if policy.BufferRequests && r.Body != nil && r.Body != http.NoBody {
+			buffered, size, err := bufferBody(r.Body, policy.MaxRequestBodyBytes)
+			r.Body.Close()
+			if err != nil {
+				if errors.Is(err, errBodyTooLarge) {
+					http.Error(w, "request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			r.Body = buffered
+			r.ContentLength = size
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// bufferResponseBody fully reads resp.Body into memory (spilling to disk
+// past bufferSpillThreshold), so the proxy can hold the whole upstream
+// response before trickling it out to a slow client. It's meant to be
+// called from httputil.ReverseProxy.ModifyResponse, which runs before any
+// bytes reach the client, so an oversized body can still turn into an
+// error response instead of a truncated one.
+func bufferResponseBody(resp *http.Response, maxBytes int64) error {
+	if resp.Body == nil || resp.Body == http.NoBody {
+		return nil
+	}
+	buffered, size, err := bufferBody(resp.Body, maxBytes)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	resp.Body = buffered
+	resp.ContentLength = size
+	resp.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	return nil
+}
+
+var byteBufferPool = sync.Pool{New: func() any { return new(bytes.Buffer) }}
+
+// bufferBody reads all of src (at most maxBytes, if positive) into a
+// sync.Pool-backed buffer, spilling to a temp file once the in-memory
+// portion exceeds bufferSpillThreshold. It returns errBodyTooLarge if src
+// has more than maxBytes available.
+func bufferBody(src io.Reader, maxBytes int64) (io.ReadCloser, int64, error) {
+	limited := src
+	if maxBytes > 0 {
+		limited = io.LimitReader(src, maxBytes+1)
+	}
+
+	buf := byteBufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+
+	n, err := io.CopyN(buf, limited, bufferSpillThreshold)
+	if err != nil && err != io.EOF {
+		byteBufferPool.Put(buf)
+		return nil, 0, err
+	}
+	if err == io.EOF || n < bufferSpillThreshold {
+		if maxBytes > 0 && int64(buf.Len()) > maxBytes {
+			byteBufferPool.Put(buf)
+			return nil, 0, errBodyTooLarge
+		}
+		size := int64(buf.Len())
+		return &memoryBody{Reader: bytes.NewReader(buf.Bytes()), buf: buf}, size, nil
+	}
+
+	// the in-memory portion alone hit the spill threshold; the rest goes
+	// to a temp file.
+	f, err := os.CreateTemp("", "gorevproxy-buf-*")
+	if err != nil {
+		byteBufferPool.Put(buf)
+		return nil, 0, err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		byteBufferPool.Put(buf)
+		return nil, 0, err
+	}
+	byteBufferPool.Put(buf)
+
+	rest, err := io.Copy(f, limited)
+	total := bufferSpillThreshold + rest
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	if maxBytes > 0 && total > maxBytes {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, errBodyTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return &fileBody{File: f}, total, nil
+}
+
+// memoryBody serves a fully in-memory buffered body; Close returns the
+// underlying buffer to the pool.
+type memoryBody struct {
+	*bytes.Reader
+	buf *bytes.Buffer
+}
+
+func (m *memoryBody) Close() error {
+	byteBufferPool.Put(m.buf)
+	return nil
+}
+
+// fileBody serves a body spilled to a temp file, deleting it on Close.
+type fileBody struct{ *os.File }
+
+func (f *fileBody) Close() error {
+	name := f.Name()
+	f.File.Close()
+	return os.Remove(name)
+}
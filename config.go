@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the on-disk proxy configuration: the upstream pool, its
+// selection policy, and the active health check settings. It is loaded
+// once at startup from a YAML (or JSON, which is valid YAML) file.
+type Config struct {
+	Policy      string            `yaml:"policy"`
+	Engine      string            `yaml:"engine"` // "standard" (default) or "fastproxy"
+	Upstreams   []UpstreamConfig  `yaml:"upstreams"`
+	HealthCheck HealthCheckConfig `yaml:"healthCheck"`
+	Buffering   BufferingPolicy   `yaml:"buffering"`
+	Timeouts    RouteTimeouts     `yaml:"timeouts"`
+	TLS         TLSConfig         `yaml:"tls"`
+}
+
+// UpstreamConfig describes a single backend entry in the config file.
+type UpstreamConfig struct {
+	URL    string `yaml:"url"`
+	Weight int    `yaml:"weight"`
+}
+
+// HealthCheckConfig controls the active health-check prober.
+type HealthCheckConfig struct {
+	Path           string        `yaml:"path"`
+	Interval       time.Duration `yaml:"interval"`
+	Timeout        time.Duration `yaml:"timeout"`
+	ExpectedStatus int           `yaml:"expectedStatus"`
+}
+
+// LoadConfig reads and parses the proxy config at path.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: read %s: %w", path, err)
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("config: parse %s: %w", path, err)
+	}
+	if len(cfg.Upstreams) == 0 {
+		return nil, fmt.Errorf("config: %s defines no upstreams", path)
+	}
+	cfg.HealthCheck.setDefaults()
+	return &cfg, nil
+}
+
+func (h *HealthCheckConfig) setDefaults() {
+	if h.Path == "" {
+		h.Path = "/healthz"
+	}
+	if h.Interval <= 0 {
+		h.Interval = 10 * time.Second
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = 2 * time.Second
+	}
+	if h.ExpectedStatus == 0 {
+		h.ExpectedStatus = http.StatusOK
+	}
+}
+
+// BuildPool constructs a Pool and its Policy from the config.
+func (c *Config) BuildPool() (*Pool, error) {
+	policy, err := NewPolicy(c.Policy)
+	if err != nil {
+		return nil, err
+	}
+	upstreams := make([]*Upstream, 0, len(c.Upstreams))
+	for _, uc := range c.Upstreams {
+		u, err := NewUpstream(uc.URL, uc.Weight)
+		if err != nil {
+			return nil, fmt.Errorf("config: invalid upstream %q: %w", uc.URL, err)
+		}
+		upstreams = append(upstreams, u)
+	}
+	return NewPool(upstreams, policy), nil
+}
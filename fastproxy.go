@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fastProxyBufSize is the size of the sync.Pool-backed byte buffers used to
+// copy request/response bodies, matching net/http's own copy buffer size.
+const fastProxyBufSize = 32 * 1024
+
+var fastProxyBufPool = sync.Pool{
+	New: func() any {
+		b := make([]byte, fastProxyBufSize)
+		return &b
+	},
+}
+
+// fastConnPoolMaxIdlePerHost bounds how many idle conns fastConnPool keeps
+// per host, so a traffic spike's worth of connections don't linger forever
+// once load drops back down.
+const fastConnPoolMaxIdlePerHost = 32
+
+// fastConnPoolIdleTimeout is how long a pooled conn may sit idle before get
+// discards it rather than handing it out, mirroring net/http.Transport's
+// IdleConnTimeout default. Idle conns past this age are more likely to have
+// already been closed by the backend, which would otherwise surface as a
+// spurious round-trip failure.
+const fastConnPoolIdleTimeout = 90 * time.Second
+
+// fastConnPool keeps a small set of idle, already-dialed backend
+// connections per host:port so steady-state requests skip the dial and TLS
+// handshake cost that httputil.ReverseProxy's Transport would otherwise
+// pay per idle-timeout cycle.
+type fastConnPool struct {
+	dialTimeout time.Duration
+
+	mu   sync.Mutex
+	idle map[string][]*pooledConn
+}
+
+type pooledConn struct {
+	net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	host      string
+	idleSince time.Time
+}
+
+func newFastConnPool(dialTimeout time.Duration) *fastConnPool {
+	if dialTimeout <= 0 {
+		dialTimeout = 5 * time.Second
+	}
+	return &fastConnPool{dialTimeout: dialTimeout, idle: make(map[string][]*pooledConn)}
+}
+
+// get returns a connection to host, along with whether it was reused from
+// the idle pool (as opposed to freshly dialed). Callers need that
+// distinction: a reused conn can have been closed by the backend while
+// idle, which is routine keep-alive churn and not evidence the upstream is
+// unhealthy, whereas a freshly dialed conn failing outright is.
+func (p *fastConnPool) get(host string) (conn *pooledConn, reused bool, err error) {
+	p.mu.Lock()
+	for {
+		conns := p.idle[host]
+		if len(conns) == 0 {
+			p.idle[host] = nil
+			break
+		}
+		c := conns[len(conns)-1]
+		p.idle[host] = conns[:len(conns)-1]
+		if time.Since(c.idleSince) > fastConnPoolIdleTimeout {
+			c.Close()
+			continue
+		}
+		p.mu.Unlock()
+		return c, true, nil
+	}
+	p.mu.Unlock()
+
+	c, err := p.dial(host)
+	return c, false, err
+}
+
+func (p *fastConnPool) dial(host string) (*pooledConn, error) {
+	conn, err := net.DialTimeout("tcp", host, p.dialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("fastproxy: dial %s: %w", host, err)
+	}
+	return &pooledConn{Conn: conn, br: bufio.NewReader(conn), bw: bufio.NewWriter(conn), host: host}, nil
+}
+
+// put returns c to the idle pool for reuse, unless host is already at
+// fastConnPoolMaxIdlePerHost, in which case c is closed instead of making
+// the idle set grow without bound.
+func (p *fastConnPool) put(c *pooledConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle[c.host]) >= fastConnPoolMaxIdlePerHost {
+		c.Close()
+		return
+	}
+	c.idleSince = time.Now()
+	p.idle[c.host] = append(p.idle[c.host], c)
+}
+
+// FastProxy is a hand-rolled HTTP/1.1 reverse proxy engine, modeled on
+// Traefik's fastproxy: it talks to backends over pooled connections with
+// bufio.Reader/Writer instead of going through net/http's client stack, to
+// cut allocations on the hot path. It only understands HTTP/1.1; ServeHTTP
+// reports an error for anything else so callers can fall back to the
+// standard httputil.ReverseProxy engine.
+type FastProxy struct {
+	pool      *Pool
+	conns     *fastConnPool
+	trusted   *TrustedProxies
+	buffering BufferingPolicy
+	fallback  http.Handler
+}
+
+// NewFastProxy builds a FastProxy selecting backends from upstreamPool.
+// Requests this engine can't handle itself (HTTP/2, or an https:// target,
+// since the pooled connections here are plain TCP with no TLS/ALPN) are
+// transparently handed to fallback, normally the standard ReverseProxy
+// engine over the same pool.
+func NewFastProxy(upstreamPool *Pool, trusted *TrustedProxies, buffering BufferingPolicy, fallback http.Handler) *FastProxy {
+	return &FastProxy{pool: upstreamPool, conns: newFastConnPool(5 * time.Second), trusted: trusted, buffering: buffering, fallback: fallback}
+}
+
+func (fp *FastProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 1 {
+		fp.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	// Publish the resolved client IP before picking an upstream: IPHashPolicy
+	// reads it back via clientIPFromContext, using ApplyForwardedHeaders's
+	// own notion of the client rather than re-deriving it. Deferring the
+	// actual header mutation (ApplyForwardedHeaders proper) until we're
+	// committed to handling this request ourselves avoids applying it
+	// twice when we fall back to the standard engine below.
+	clientIP := remoteIP(r)
+	*r = *r.WithContext(context.WithValue(r.Context(), clientIPContextKey, clientIP))
+
+	up, err := fp.pool.Pick(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if up.URL.Scheme == "https" {
+		fp.fallback.ServeHTTP(w, r)
+		return
+	}
+
+	clientIP = ApplyForwardedHeaders(r, fp.trusted)
+	state := requestStateFromContext(r.Context())
+	state.setClientIP(clientIP)
+	state.setUpstream(up)
+
+	if r.Header.Get("X-Request-ID") == "" {
+		r.Header.Set("X-Request-ID", time.Now().Format("20060102T150405.000000"))
+	}
+	state.setRequestID(r.Header.Get("X-Request-ID"))
+	injectTraceparent(r)
+
+	// Mirror the standard Director's incConns/decConns bracketing so
+	// least_conn and the weighted policy see accurate in-flight counts
+	// when this engine is in use, not just under the standard engine.
+	up.incConns()
+	defer up.decConns()
+
+	conn, reused, err := fp.conns.get(up.URL.Host)
+	if err != nil {
+		fp.pool.MarkUnhealthy(up)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if isWebsocketUpgrade(r) {
+		fp.proxyWebsocket(w, r, conn, up)
+		return
+	}
+	fp.proxyHTTP(w, r, conn, up, reused)
+}
+
+func (fp *FastProxy) proxyHTTP(w http.ResponseWriter, r *http.Request, conn *pooledConn, up *Upstream, reused bool) {
+	resp, err := fastProxyRoundTrip(conn, r, up.URL.Host)
+	if err != nil && reused && isIdempotentFastProxyRequest(r) {
+		// The backend may simply have closed this idle pooled conn on its
+		// own timeout, which looks identical to it being down; retry once
+		// on a freshly dialed conn, the same way net/http's Transport
+		// silently retries idempotent requests on a new conn, before
+		// treating the upstream as unhealthy.
+		conn.Close()
+		fresh, dialErr := fp.conns.dial(up.URL.Host)
+		if dialErr != nil {
+			fp.pool.MarkUnhealthy(up)
+			http.Error(w, dialErr.Error(), http.StatusBadGateway)
+			return
+		}
+		conn = fresh
+		reused = false
+		resp, err = fastProxyRoundTrip(conn, r, up.URL.Host)
+	}
+	if err != nil {
+		conn.Close()
+		if !reused {
+			// Only evict the upstream for a failure on a connection we just
+			// dialed (or freshly redialed by the idempotent retry above):
+			// that's a genuine connection failure. A reused pooled conn
+			// failing on a non-idempotent request is routine keep-alive
+			// churn, not evidence the backend is down, so just fail the
+			// request and let the health checker decide.
+			fp.pool.MarkUnhealthy(up)
+		}
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if fp.buffering.BufferResponses {
+		if err := bufferResponseBody(resp, fp.buffering.MaxResponseBodyBytes); err != nil {
+			conn.Close()
+			if errors.Is(err, errBodyTooLarge) {
+				http.Error(w, "response body too large", http.StatusBadGateway)
+				return
+			}
+			// A failure here is the buffering layer (e.g. a transient read
+			// error draining the body), not evidence the upstream is down,
+			// so just fail the request without marking it unhealthy.
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	removeHopByHopHeaders(resp.Header)
+	dst := w.Header()
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	bufp := fastProxyBufPool.Get().(*[]byte)
+	_, copyErr := io.CopyBuffer(w, resp.Body, *bufp)
+	fastProxyBufPool.Put(bufp)
+
+	if copyErr != nil || resp.Close || r.Close {
+		conn.Close()
+		return
+	}
+	fp.conns.put(conn)
+}
+
+// fastProxyRoundTrip writes r onto conn and reads back the response,
+// shared by proxyHTTP's initial attempt and its fresh-connection retry.
+func fastProxyRoundTrip(conn *pooledConn, r *http.Request, hostHeader string) (*http.Response, error) {
+	if err := writeProxyRequest(conn.bw, r, hostHeader, false); err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(conn.br, r)
+}
+
+// isIdempotentFastProxyRequest reports whether r is safe to retry on a
+// fresh connection: methods with no request body to have been partially
+// consumed already, mirroring net/http's own retry-idempotent-requests
+// behavior.
+func isIdempotentFastProxyRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
+
+// proxyWebsocket performs the HTTP/1.1 Upgrade handshake over the pooled
+// backend connection and, once the backend answers 101, hijacks the client
+// connection and splices the two raw byte streams together.
+func (fp *FastProxy) proxyWebsocket(w http.ResponseWriter, r *http.Request, conn *pooledConn, up *Upstream) {
+	if err := writeProxyRequest(conn.bw, r, up.URL.Host, true); err != nil {
+		conn.Close()
+		fp.pool.MarkUnhealthy(up)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	resp, err := http.ReadResponse(conn.br, r)
+	if err != nil {
+		conn.Close()
+		fp.pool.MarkUnhealthy(up)
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		dst := w.Header()
+		for k, vv := range resp.Header {
+			for _, v := range vv {
+				dst.Add(k, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		conn.Close()
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		conn.Close()
+		http.Error(w, "fastproxy: websocket upgrade requires hijacking", http.StatusInternalServerError)
+		return
+	}
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	fmt.Fprintf(clientBuf, "HTTP/1.1 101 Switching Protocols\r\n")
+	resp.Header.Write(clientBuf)
+	clientBuf.WriteString("\r\n")
+	if err := clientBuf.Flush(); err != nil {
+		clientConn.Close()
+		conn.Close()
+		return
+	}
+
+	go splice(conn.Conn, clientConn)
+	splice(clientConn, conn.Conn)
+}
+
+// writeProxyRequest serializes r onto bw as an HTTP/1.1 request line plus
+// headers, stripping hop-by-hop headers (unless this is a websocket
+// upgrade, in which case Connection/Upgrade must survive) and re-framing
+// the body as either Content-Length or chunked.
+func writeProxyRequest(bw *bufio.Writer, r *http.Request, hostHeader string, isUpgrade bool) error {
+	uri := r.URL.RequestURI()
+	if uri == "" {
+		uri = "/"
+	}
+	if _, err := fmt.Fprintf(bw, "%s %s HTTP/1.1\r\n", r.Method, uri); err != nil {
+		return err
+	}
+
+	header := r.Header.Clone()
+	if isUpgrade {
+		header.Set("Connection", "Upgrade")
+		header.Set("Upgrade", r.Header.Get("Upgrade"))
+	} else {
+		removeHopByHopHeaders(header)
+	}
+	header.Del("Host")
+	if r.ContentLength >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(r.ContentLength, 10))
+		header.Del("Transfer-Encoding")
+	} else if !isUpgrade {
+		header.Set("Transfer-Encoding", "chunked")
+	}
+
+	if _, err := fmt.Fprintf(bw, "Host: %s\r\n", hostHeader); err != nil {
+		return err
+	}
+	if err := header.Write(bw); err != nil {
+		return err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return err
+	}
+
+	if r.Body == nil || r.Body == http.NoBody {
+		return bw.Flush()
+	}
+
+	bufp := fastProxyBufPool.Get().(*[]byte)
+	defer fastProxyBufPool.Put(bufp)
+
+	if r.ContentLength >= 0 || isUpgrade {
+		if _, err := io.CopyBuffer(bw, r.Body, *bufp); err != nil {
+			return err
+		}
+	} else {
+		cw := httputil.NewChunkedWriter(bw)
+		if _, err := io.CopyBuffer(cw, r.Body, *bufp); err != nil {
+			return err
+		}
+		if err := cw.Close(); err != nil {
+			return err
+		}
+		if _, err := bw.WriteString("\r\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func isWebsocketUpgrade(r *http.Request) bool {
+	return strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade")
+}
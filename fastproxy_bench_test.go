@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func benchBackend(tb testing.TB) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+}
+
+func BenchmarkFastProxy(b *testing.B) {
+	backend := benchBackend(b)
+	defer backend.Close()
+
+	up, err := NewUpstream(backend.URL, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pool := NewPool([]*Upstream{up}, &RoundRobinPolicy{})
+	fp := NewFastProxy(pool, &TrustedProxies{}, BufferingPolicy{}, http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		fp.ServeHTTP(rec, req.Clone(req.Context()))
+		io.Copy(io.Discard, rec.Body)
+	}
+}
+
+func BenchmarkStandardReverseProxy(b *testing.B) {
+	backend := benchBackend(b)
+	defer backend.Close()
+
+	up, err := NewUpstream(backend.URL, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	pool := NewPool([]*Upstream{up}, &RoundRobinPolicy{})
+	proxy := makeReverseProxy(pool, newTransport(), &TrustedProxies{}, BufferingPolicy{})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, req.Clone(req.Context()))
+		io.Copy(io.Discard, rec.Body)
+	}
+}
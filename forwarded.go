@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies is a set of CIDR ranges (or bare IPs, treated as /32 or
+// /128) whose incoming X-Forwarded-*/Forwarded headers we trust enough to
+// extend rather than discard.
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs/IPs.
+func ParseTrustedProxies(raw string) (*TrustedProxies, error) {
+	tp := &TrustedProxies{}
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(part); err == nil {
+			tp.nets = append(tp.nets, n)
+			continue
+		}
+		ip := net.ParseIP(part)
+		if ip == nil {
+			return nil, fmt.Errorf("forwarded: invalid trusted proxy %q", part)
+		}
+		bits := 32
+		if ip.To4() == nil {
+			bits = 128
+		}
+		tp.nets = append(tp.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return tp, nil
+}
+
+// Contains reports whether ip falls within any of the trusted ranges.
+func (tp *TrustedProxies) Contains(ip string) bool {
+	if tp == nil {
+		return false
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range tp.nets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP extracts the bare IP from r.RemoteAddr.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ApplyForwardedHeaders sets X-Forwarded-For, X-Forwarded-Proto,
+// X-Forwarded-Host, X-Real-IP and Forwarded on r for the hop to the
+// upstream. If the immediate peer (r.RemoteAddr) is in trusted, its
+// existing forwarded headers are extended; otherwise they're stripped and
+// replaced so an untrusted client can't spoof them. It returns the
+// resolved client IP.
+func ApplyForwardedHeaders(r *http.Request, trusted *TrustedProxies) string {
+	clientIP := remoteIP(r)
+	isTrustedHop := trusted.Contains(clientIP)
+
+	if !isTrustedHop {
+		r.Header.Del("X-Forwarded-For")
+		r.Header.Del("X-Forwarded-Proto")
+		r.Header.Del("X-Forwarded-Host")
+		r.Header.Del("X-Real-IP")
+		r.Header.Del("Forwarded")
+	}
+
+	if prior := r.Header.Get("X-Forwarded-For"); prior != "" {
+		r.Header.Set("X-Forwarded-For", prior+", "+clientIP)
+	} else {
+		r.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if r.Header.Get("X-Real-IP") == "" {
+		r.Header.Set("X-Real-IP", clientIP)
+	}
+
+	proto := "http"
+	if r.TLS != nil {
+		proto = "https"
+	}
+	if r.Header.Get("X-Forwarded-Proto") == "" {
+		r.Header.Set("X-Forwarded-Proto", proto)
+	}
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+
+	forwardedFor := clientIP
+	if strings.Contains(clientIP, ":") {
+		forwardedFor = `"[` + clientIP + `]"`
+	}
+	entry := fmt.Sprintf("for=%s;host=%s;proto=%s", forwardedFor, r.Host, proto)
+	if prior := r.Header.Get("Forwarded"); prior != "" {
+		r.Header.Set("Forwarded", prior+", "+entry)
+	} else {
+		r.Header.Set("Forwarded", entry)
+	}
+
+	return clientIP
+}
+
+// clientIPFromContext retrieves the client IP resolved by
+// ApplyForwardedHeaders, for use by downstream middleware such as rate
+// limiting or logging.
+func clientIPFromContext(ctx context.Context) (string, bool) {
+	ip, ok := ctx.Value(clientIPContextKey).(string)
+	return ip, ok
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// proxyChainFlag collects repeated -proxy flag occurrences into an ordered
+// chain of upstream proxy hops.
+type proxyChainFlag struct {
+	hops []*ProxyHop
+}
+
+func (f *proxyChainFlag) String() string {
+	parts := make([]string, len(f.hops))
+	for i, h := range f.hops {
+		parts[i] = h.Scheme + "://" + h.Host
+	}
+	return strings.Join(parts, ",")
+}
+
+func (f *proxyChainFlag) Set(value string) error {
+	hop, err := ParseProxyHop(value)
+	if err != nil {
+		return err
+	}
+	f.hops = append(f.hops, hop)
+	return nil
+}
+
+// runForwardProxy builds and serves a ForwardProxy on addr, chaining
+// through hops and optionally requiring htpasswd authentication.
+func runForwardProxy(addr string, hops []*ProxyHop, htpasswdPath string) {
+	var users *UserStore
+	if htpasswdPath != "" {
+		var err error
+		users, err = LoadHtpasswd(htpasswdPath)
+		if err != nil {
+			slog.Error("forwardproxy", "error", err)
+			os.Exit(1)
+		}
+	} else {
+		slog.Warn("forwardproxy: no -htpasswd given, running without authentication")
+	}
+
+	fp, err := NewForwardProxy(hops, users)
+	if err != nil {
+		slog.Error("forwardproxy", "error", err)
+		os.Exit(1)
+	}
+
+	srv := &http.Server{
+		Addr:         addr,
+		Handler:      fp,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 0, // CONNECT tunnels can run indefinitely
+		IdleTimeout:  120 * time.Second,
+	}
+	slog.Info("starting forward proxy", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		slog.Error("forward proxy ListenAndServe", "error", err)
+		os.Exit(1)
+	}
+}
+
+// ForwardProxy implements a forward HTTP/HTTPS proxy: it tunnels CONNECT
+// requests byte-for-byte to the destination and relays absolute-form plain
+// HTTP requests through its own client, optionally dialing through a chain
+// of upstream proxies (see ProxyHop/BuildChainDialer). Unlike the
+// reverse-proxy handler, it has no notion of a single upstream target.
+type ForwardProxy struct {
+	dial   proxy.Dialer
+	client *http.Client
+	users  *UserStore // nil disables authentication
+}
+
+// NewForwardProxy builds a ForwardProxy that dials through chain (may be
+// empty for a direct connection) and, if users is non-nil, requires
+// Basic/htpasswd authentication on every request.
+func NewForwardProxy(chain []*ProxyHop, users *UserStore) (*ForwardProxy, error) {
+	dialer, err := BuildChainDialer(chain)
+	if err != nil {
+		return nil, err
+	}
+	transport := &http.Transport{
+		DialContext: func(_ context.Context, network, addr string) (net.Conn, error) {
+			return dialer.Dial(network, addr)
+		},
+	}
+	return &ForwardProxy{
+		dial:   dialer,
+		client: &http.Client{Transport: transport},
+		users:  users,
+	}, nil
+}
+
+func (fp *ForwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if fp.users != nil && !fp.users.Authenticate(r) {
+		w.Header().Set("Proxy-Authenticate", `Basic realm="gorevproxy"`)
+		http.Error(w, "Proxy Authentication Required", http.StatusProxyAuthRequired)
+		return
+	}
+	r.Header.Del("Proxy-Authorization")
+
+	if r.Method == http.MethodConnect {
+		fp.handleConnect(w, r)
+		return
+	}
+	fp.handleForward(w, r)
+}
+
+// handleConnect dials the requested host through the proxy chain, hijacks
+// the client connection and splices the two together.
+func (fp *ForwardProxy) handleConnect(w http.ResponseWriter, r *http.Request) {
+	destConn, err := fp.dial.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		destConn.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	clientConn, _, err := hijacker.Hijack()
+	if err != nil {
+		destConn.Close()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if _, err := clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		clientConn.Close()
+		destConn.Close()
+		return
+	}
+
+	go splice(destConn, clientConn)
+	splice(clientConn, destConn)
+}
+
+// handleForward relays an absolute-form plain HTTP request to its target
+// through fp.client.
+func (fp *ForwardProxy) handleForward(w http.ResponseWriter, r *http.Request) {
+	if !r.URL.IsAbs() {
+		http.Error(w, "forward proxy requires an absolute-form request URI", http.StatusBadRequest)
+		return
+	}
+
+	outReq := r.Clone(r.Context())
+	outReq.RequestURI = ""
+	removeHopByHopHeaders(outReq.Header)
+
+	resp, err := fp.client.Do(outReq)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	dst := w.Header()
+	for k, vv := range resp.Header {
+		for _, v := range vv {
+			dst.Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+var hopByHopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive",
+	"Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+func removeHopByHopHeaders(h http.Header) {
+	for _, k := range hopByHopHeaders {
+		h.Del(k)
+	}
+}
+
+// splice copies src into dst until either side closes, closing both ends
+// when done so the other goroutine's copy unblocks.
+func splice(dst, src net.Conn) {
+	defer dst.Close()
+	defer src.Close()
+	if _, err := io.Copy(dst, src); err != nil {
+		slog.Debug("forwardproxy: tunnel copy error", "src", src.RemoteAddr(), "dst", dst.RemoteAddr(), "error", err)
+	}
+}
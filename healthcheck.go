@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// StartHealthChecker launches a background goroutine that probes every
+// upstream in pool on cfg.Interval and updates its health accordingly. The
+// goroutine stops when ctx is canceled.
+func StartHealthChecker(ctx context.Context, pool *Pool, cfg HealthCheckConfig) {
+	client := &http.Client{Timeout: cfg.Timeout}
+	ticker := time.NewTicker(cfg.Interval)
+
+	// probe once immediately so the pool doesn't start out assuming every
+	// upstream is healthy for a full interval.
+	probeAll(ctx, pool, cfg, client)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				probeAll(ctx, pool, cfg, client)
+			}
+		}
+	}()
+}
+
+func probeAll(ctx context.Context, pool *Pool, cfg HealthCheckConfig, client *http.Client) {
+	for _, u := range pool.All() {
+		go probeOne(ctx, u, cfg, client)
+	}
+}
+
+func probeOne(ctx context.Context, u *Upstream, cfg HealthCheckConfig, client *http.Client) {
+	reqCtx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+	defer cancel()
+
+	target := *u.URL
+	target.Path = cfg.Path
+
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodGet, target.String(), nil)
+	if err != nil {
+		u.SetHealthy(false)
+		return
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		u.SetHealthy(false)
+		return
+	}
+	defer resp.Body.Close()
+	u.SetHealthy(resp.StatusCode == cfg.ExpectedStatus)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserStore holds the username -> password-hash entries parsed from an
+// htpasswd file, used to gate access to the forward proxy. Only bcrypt
+// ($2y$/$2a$/$2b$) entries are supported, since that's the one htpasswd
+// format Go can verify without shelling out to crypt(3).
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[string]string
+}
+
+// LoadHtpasswd parses the htpasswd file at path.
+func LoadHtpasswd(path string) (*UserStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("htpasswd: read %s: %w", path, err)
+	}
+	users := make(map[string]string)
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		users[name] = hash
+	}
+	return &UserStore{users: users}, nil
+}
+
+// Authenticate checks the Proxy-Authorization header of r against the
+// store, returning true only for a valid bcrypt-verified credential.
+func (s *UserStore) Authenticate(r *http.Request) bool {
+	user, pass, ok := parseProxyBasicAuth(r.Header.Get("Proxy-Authorization"))
+	if !ok {
+		return false
+	}
+	s.mu.RLock()
+	hash, exists := s.users[user]
+	s.mu.RUnlock()
+	if !exists || !strings.HasPrefix(hash, "$2") {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil
+}
+
+func parseProxyBasicAuth(header string) (user, pass string, ok bool) {
+	const prefix = "Basic "
+	if !strings.HasPrefix(header, prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(header[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	name, pw, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return "", "", false
+	}
+	return name, pw, true
+}
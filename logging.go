@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AccessLogConfig controls where one-line-per-request structured access
+// log records are written and how heavily they're sampled.
+type AccessLogConfig struct {
+	Sink       string  `yaml:"sink"`       // "stdout" (default), "file" or "syslog"
+	FilePath   string  `yaml:"filePath"`   // required when Sink == "file"
+	SampleRate float64 `yaml:"sampleRate"` // fraction of requests logged, 0 < rate <= 1; default 1
+}
+
+func (c *AccessLogConfig) setDefaults() {
+	if c.Sink == "" {
+		c.Sink = "stdout"
+	}
+	if c.SampleRate <= 0 {
+		c.SampleRate = 1
+	}
+}
+
+// NewAccessLogger builds the slog.Logger used for access-log records,
+// writing JSON lines to the sink named by cfg.
+func NewAccessLogger(cfg AccessLogConfig) (*slog.Logger, error) {
+	cfg.setDefaults()
+	w, err := openLogSink(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return slog.New(slog.NewJSONHandler(w, nil)), nil
+}
+
+func openLogSink(cfg AccessLogConfig) (io.Writer, error) {
+	switch cfg.Sink {
+	case "", "stdout":
+		return os.Stdout, nil
+	case "file":
+		if cfg.FilePath == "" {
+			return nil, fmt.Errorf("logging: file sink requires filePath")
+		}
+		return os.OpenFile(cfg.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	case "syslog":
+		return newSyslogWriter()
+	default:
+		return nil, fmt.Errorf("logging: unknown sink %q", cfg.Sink)
+	}
+}
+
+// AccessLogMiddleware emits one JSON record per request to logger,
+// containing method, URL, upstream target, client IP, status, bytes
+// in/out, upstream latency, total latency and the request's X-Request-ID.
+// Records are sampled at cfg.SampleRate.
+func AccessLogMiddleware(logger *slog.Logger, cfg AccessLogConfig, next http.Handler) http.Handler {
+	cfg.setDefaults()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		timing := &requestTiming{}
+		ctx, state := withRequestState(withTiming(r.Context(), timing))
+		r = r.WithContext(ctx)
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate {
+			return
+		}
+
+		clientIP, upstream, requestID := state.snapshot()
+		var upstreamTarget string
+		if upstream != nil {
+			upstreamTarget = upstream.URL.String()
+		}
+		var upstreamLatency time.Duration
+		if !timing.upstreamStart.IsZero() && !timing.upstreamEnd.IsZero() {
+			upstreamLatency = timing.upstreamEnd.Sub(timing.upstreamStart)
+		}
+
+		logger.Info("access",
+			"method", r.Method,
+			"url", r.URL.String(),
+			"upstream", upstreamTarget,
+			"clientIP", clientIP,
+			"status", rec.status,
+			"bytesIn", r.ContentLength,
+			"bytesOut", rec.bytes,
+			"upstreamLatencyMs", upstreamLatency.Milliseconds(),
+			"totalLatencyMs", time.Since(start).Milliseconds(),
+			"requestID", requestID,
+		)
+	})
+}
+
+// statusRecorder wraps a ResponseWriter to capture the status code and
+// byte count written, while still passing Hijack/Flush through so
+// websocket upgrades and streaming responses keep working underneath it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := s.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("logging: underlying ResponseWriter does not support hijacking")
+	}
+	return h.Hijack()
+}
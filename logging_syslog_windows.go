@@ -0,0 +1,12 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+func newSyslogWriter() (io.Writer, error) {
+	return nil, fmt.Errorf("logging: syslog sink is not supported on windows")
+}
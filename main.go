@@ -3,17 +3,28 @@ package main
 import (
 	"context"
 	"crypto/tls"
-	"log"
+	"errors"
+	"flag"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
 	"os/signal"
-	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const maxProxyRetries = 2
+
+type contextKey int
+
+const (
+	upstreamContextKey contextKey = iota
+	retryCountContextKey
+	clientIPContextKey
+)
+
 func newTransport() *http.Transport {
 	return &http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
@@ -28,12 +39,102 @@ func newTransport() *http.Transport {
 	}
 }
 
+// defaultTLSConfig is used when -config doesn't set a tls section, matching
+// the server.crt/server.key pair this proxy has always looked for.
+var defaultTLSConfig = TLSConfig{Certificates: []CertPairConfig{{CertFile: "server.crt", KeyFile: "server.key"}}}
+
+// loadPool builds the upstream pool from -config, falling back to a single
+// localhost:8081 upstream (the previous hard-coded default) if no config
+// file was given or it failed to load, so the proxy still starts in dev. It
+// also returns the configured proxy engine ("standard" or "fastproxy"), the
+// route's buffering policy/timeouts, and the TLS config.
+func loadPool(path string) (*Pool, string, BufferingPolicy, RouteTimeouts, TLSConfig) {
+	if path != "" {
+		cfg, err := LoadConfig(path)
+		if err == nil {
+			pool, err := cfg.BuildPool()
+			if err == nil {
+				StartHealthChecker(context.Background(), pool, cfg.HealthCheck)
+				tlsCfg := cfg.TLS
+				if len(tlsCfg.Certificates) == 0 && !tlsCfg.Autocert.Enabled {
+					tlsCfg = defaultTLSConfig
+				}
+				return pool, cfg.Engine, cfg.Buffering, cfg.Timeouts, tlsCfg
+			}
+			slog.Error("config: building pool failed", "path", path, "error", err)
+		} else {
+			slog.Error("config: load failed", "error", err)
+		}
+	}
+
+	slog.Warn("no usable -config given, falling back to single localhost:8081 upstream")
+	fallback, _ := NewUpstream("http://localhost:8081", 1)
+	return NewPool([]*Upstream{fallback}, &RoundRobinPolicy{}), "standard", BufferingPolicy{}, RouteTimeouts{}, defaultTLSConfig
+}
+
 func main() {
-	target, _ := url.Parse("http://localhost:8081") // example backend
+	configPath := flag.String("config", "upstreams.yaml", "path to the upstream pool config (YAML or JSON)")
+	forwardProxyAddr := flag.String("forward-proxy-addr", "", "if set, also listen here as a CONNECT/forward HTTP proxy")
+	htpasswdPath := flag.String("htpasswd", "", "htpasswd file (bcrypt entries only) required to use the forward proxy")
+	var proxyChainFlags proxyChainFlag
+	flag.Var(&proxyChainFlags, "proxy", "upstream proxy to chain through before reaching the target, e.g. socks5://host:1080 (repeatable)")
+	trustedProxiesFlag := flag.String("trusted-proxies", "", "comma-separated CIDRs/IPs allowed to set their own X-Forwarded-*/Forwarded headers")
+	logSink := flag.String("log-sink", "stdout", "access log sink: stdout, file or syslog")
+	logFile := flag.String("log-file", "", "file path for -log-sink=file")
+	logSampleRate := flag.Float64("log-sample-rate", 1, "fraction of requests (0,1] written to the access log")
+	enableTracing := flag.Bool("tracing", false, "export OpenTelemetry spans (as JSON) to stdout")
+	flag.Parse()
+
+	trustedProxies, err := ParseTrustedProxies(*trustedProxiesFlag)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	accessLogCfg := AccessLogConfig{Sink: *logSink, FilePath: *logFile, SampleRate: *logSampleRate}
+	accessLogger, err := NewAccessLogger(accessLogCfg)
+	if err != nil {
+		slog.Error(err.Error())
+		os.Exit(1)
+	}
+	slog.SetDefault(accessLogger)
+
+	var tracingShutdown func(context.Context) error
+	if *enableTracing {
+		tracingShutdown, err = InitTracing(os.Stdout)
+		if err != nil {
+			slog.Error("tracing: init failed", "error", err)
+			os.Exit(1)
+		}
+	}
+
+	pool, engine, bufferingPolicy, routeTimeouts, tlsCfg := loadPool(*configPath)
 	transport := newTransport()
 
-	// simple health check endpoint and readiness switching
-	var ready int32 = 1
+	certManager, err := NewCertManager(tlsCfg)
+	if err != nil {
+		slog.Error("tls: init failed", "error", err)
+		os.Exit(1)
+	}
+	watchCtx, stopWatch := context.WithCancel(context.Background())
+	defer stopWatch()
+	certManager.WatchForChanges(watchCtx, 30*time.Second)
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		for range sighup {
+			slog.Info("tls: SIGHUP received, reloading certificates")
+			if err := certManager.Reload(); err != nil {
+				slog.Error("tls: reload failed", "error", err)
+			}
+		}
+	}()
+
+	if *forwardProxyAddr != "" {
+		go runForwardProxy(*forwardProxyAddr, proxyChainFlags.hops, *htpasswdPath)
+	}
+
 	mux := http.NewServeMux()
 
 	mux.Handle("/healthz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,22 +142,41 @@ func main() {
 		w.Write([]byte("ok"))
 	}))
 	mux.Handle("/readyz", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if atomic.LoadInt32(&ready) == 1 {
-			w.WriteHeader(http.StatusOK)
-			w.Write([]byte("ready"))
+		if pool.HealthyCount() == 0 {
+			http.Error(w, "no healthy upstreams", http.StatusServiceUnavailable)
 			return
 		}
-		http.Error(w, "not ready", http.StatusServiceUnavailable)
+		if certManager.Reloading() {
+			http.Error(w, "tls certificate reload in progress", http.StatusServiceUnavailable)
+			return
+		}
+		if certManager.Expired() {
+			http.Error(w, "tls certificate expired", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ready"))
 	}))
 
-	proxy := makeReverseProxy(target, transport)
+	standardProxy := makeReverseProxy(pool, transport, trustedProxies, bufferingPolicy)
+	var handler http.Handler = standardProxy
+	if engine == "fastproxy" {
+		slog.Info("using fastproxy engine")
+		handler = NewFastProxy(pool, trustedProxies, bufferingPolicy, standardProxy)
+	}
+	handler = WrapRoute(handler, bufferingPolicy, routeTimeouts)
+	handler = AccessLogMiddleware(accessLogger, accessLogCfg, handler)
+	if *enableTracing {
+		handler = TracingMiddleware(handler)
+	}
 
 	// main proxy handler with a simple rate-limit / concurrency guard could be added here
-	mux.Handle("/", proxy)
+	mux.Handle("/", handler)
 
 	srv := &http.Server{
 		Addr:         ":8443",
 		Handler:      mux,
+		TLSConfig:    certManager.TLSConfig(),
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 30 * time.Second,
 		IdleTimeout:  120 * time.Second,
@@ -68,49 +188,108 @@ func main() {
 		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt)
 		<-c
-		atomic.StoreInt32(&ready, 0) // mark not ready for k8s readiness
 		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 		defer cancel()
 		if err := srv.Shutdown(ctx); err != nil {
-			log.Printf("HTTP server Shutdown: %v", err)
+			slog.Error("HTTP server Shutdown", "error", err)
+		}
+		if tracingShutdown != nil {
+			if err := tracingShutdown(ctx); err != nil {
+				slog.Error("tracing: shutdown", "error", err)
+			}
 		}
 		close(idleConnsClosed)
 	}()
 
-	log.Println("starting proxy on :8443")
-	if err := srv.ListenAndServeTLS("server.crt", "server.key"); err != http.ErrServerClosed {
-		log.Fatalf("ListenAndServeTLS: %v", err)
+	slog.Info("starting proxy on :8443")
+	if err := srv.ListenAndServeTLS("", ""); err != http.ErrServerClosed {
+		slog.Error("ListenAndServeTLS", "error", err)
+		os.Exit(1)
 	}
 	<-idleConnsClosed
-	log.Println("server stopped")
+	slog.Info("server stopped")
 }
 
-func makeReverseProxy(target *url.URL, transport *http.Transport) *httputil.ReverseProxy {
-	proxy := httputil.NewSingleHostReverseProxy(target)
-	proxy.Transport = transport
+// makeReverseProxy builds a reverse proxy that selects an upstream from pool
+// per request (instead of proxying to a single fixed target), marking an
+// upstream unhealthy and retrying on another one when it fails to connect.
+func makeReverseProxy(pool *Pool, transport *http.Transport, trustedProxies *TrustedProxies, buffering BufferingPolicy) *httputil.ReverseProxy {
+	proxy := &httputil.ReverseProxy{FlushInterval: buffering.FlushInterval}
 
-	// rewrite requests if needed
-	originalDirector := proxy.Director
 	proxy.Director = func(r *http.Request) {
-		originalDirector(r)
-		// set a header for tracing
+		clientIP := ApplyForwardedHeaders(r, trustedProxies)
+		*r = *r.WithContext(context.WithValue(r.Context(), clientIPContextKey, clientIP))
+		requestStateFromContext(r.Context()).setClientIP(clientIP)
+
+		up, err := pool.Pick(r)
+		if err != nil {
+			// leave the request unmodified; ErrorHandler only fires once the
+			// transport actually tries to dial, so force that to happen by
+			// pointing at an address nothing is listening on.
+			r.URL.Scheme = "http"
+			r.URL.Host = "127.0.0.1:0"
+			return
+		}
+
+		*r = *r.WithContext(context.WithValue(r.Context(), upstreamContextKey, up))
+		requestStateFromContext(r.Context()).setUpstream(up)
+		up.incConns()
+
+		r.URL.Scheme = up.URL.Scheme
+		r.URL.Host = up.URL.Host
+		r.Host = up.URL.Host
+
 		if r.Header.Get("X-Request-ID") == "" {
 			r.Header.Set("X-Request-ID", time.Now().Format("20060102T150405.000000"))
 		}
-		// drop hop-by-hop headers we don't want forwarded
+		requestStateFromContext(r.Context()).setRequestID(r.Header.Get("X-Request-ID"))
 		r.Header.Del("Proxy-Connection")
+		injectTraceparent(r)
 	}
 
-	// inspect/modify responses
+	proxy.Transport = &timingRoundTripper{next: transport}
+
 	proxy.ModifyResponse = func(resp *http.Response) error {
-		// add server header
+		if up, ok := resp.Request.Context().Value(upstreamContextKey).(*Upstream); ok {
+			up.decConns()
+		}
+		if buffering.BufferResponses {
+			if err := bufferResponseBody(resp, buffering.MaxResponseBodyBytes); err != nil {
+				// Wrapped so ErrorHandler can tell this apart from a
+				// transport-level failure: the round trip already succeeded
+				// and decConns has already run above, so ErrorHandler must
+				// not decrement again or treat the upstream as unhealthy
+				// over what's a buffering-layer error, not a dead backend.
+				return &modifyResponseError{err}
+			}
+		}
 		resp.Header.Set("Via", "MyGoProxy/1.0")
 		return nil
 	}
 
-	// centralized error handling
 	proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
-		log.Printf("proxy error: %v %s %s", err, r.Method, r.URL)
+		if errors.Is(err, errBodyTooLarge) {
+			http.Error(w, "response body too large", http.StatusBadGateway)
+			return
+		}
+
+		var modErr *modifyResponseError
+		fromBuffering := errors.As(err, &modErr)
+
+		if up, ok := r.Context().Value(upstreamContextKey).(*Upstream); ok && !fromBuffering {
+			up.decConns()
+			pool.MarkUnhealthy(up)
+			slog.Warn("upstream marked unhealthy", "upstream", up.URL.String(), "error", err)
+		}
+
+		retries, _ := r.Context().Value(retryCountContextKey).(int)
+		if retries < maxProxyRetries && pool.HealthyCount() > 0 {
+			retryReq := r.Clone(context.WithValue(r.Context(), retryCountContextKey, retries+1))
+			proxy.ServeHTTP(w, retryReq)
+			return
+		}
+
+		slog.Error("proxy error", "error", err, "method", r.Method, "url", r.URL.String())
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 	}
 
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy selects one upstream from a slice of currently-healthy candidates
+// for a given request. Implementations must be safe for concurrent use.
+type Policy interface {
+	Pick(r *http.Request, healthy []*Upstream) (*Upstream, error)
+}
+
+// NewPolicy builds the Policy named by kind, one of "round_robin",
+// "least_conn", "weighted", "random" or "ip_hash".
+func NewPolicy(kind string) (Policy, error) {
+	switch kind {
+	case "", "round_robin":
+		return &RoundRobinPolicy{}, nil
+	case "least_conn":
+		return &LeastConnPolicy{}, nil
+	case "weighted":
+		return &WeightedPolicy{}, nil
+	case "random":
+		return &RandomPolicy{}, nil
+	case "ip_hash":
+		return &IPHashPolicy{}, nil
+	default:
+		return nil, fmt.Errorf("policy: unknown selection policy %q", kind)
+	}
+}
+
+// RoundRobinPolicy cycles through healthy upstreams in order.
+type RoundRobinPolicy struct {
+	counter uint64
+}
+
+func (p *RoundRobinPolicy) Pick(r *http.Request, healthy []*Upstream) (*Upstream, error) {
+	n := atomic.AddUint64(&p.counter, 1)
+	return healthy[(n-1)%uint64(len(healthy))], nil
+}
+
+// LeastConnPolicy sends each request to the healthy upstream with the
+// fewest in-flight requests.
+type LeastConnPolicy struct{}
+
+func (p *LeastConnPolicy) Pick(r *http.Request, healthy []*Upstream) (*Upstream, error) {
+	best := healthy[0]
+	for _, u := range healthy[1:] {
+		if u.Conns() < best.Conns() {
+			best = u
+		}
+	}
+	return best, nil
+}
+
+// WeightedPolicy distributes requests across healthy upstreams in
+// proportion to their configured Weight using the same smooth weighted
+// round-robin algorithm nginx uses: each pick bumps every upstream's
+// running currentWeight by its (floor-1) effective weight, hands the
+// request to whichever now has the highest currentWeight, then knocks the
+// total weight back off that one. Over a run of picks this converges on
+// each upstream's share of requests matching its share of total weight,
+// without clustering same-weight picks together the way a naive "highest
+// weight wins" comparison would.
+type WeightedPolicy struct {
+	mu      sync.Mutex
+	current map[*Upstream]int
+}
+
+func (p *WeightedPolicy) Pick(r *http.Request, healthy []*Upstream) (*Upstream, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.current == nil {
+		p.current = make(map[*Upstream]int)
+	}
+
+	total := 0
+	var best *Upstream
+	for _, u := range healthy {
+		weight := u.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		total += weight
+		p.current[u] += weight
+		if best == nil || p.current[u] > p.current[best] {
+			best = u
+		}
+	}
+	p.current[best] -= total
+	return best, nil
+}
+
+// RandomPolicy picks a uniformly random healthy upstream.
+type RandomPolicy struct{}
+
+func (p *RandomPolicy) Pick(r *http.Request, healthy []*Upstream) (*Upstream, error) {
+	return healthy[rand.Intn(len(healthy))], nil
+}
+
+// IPHashPolicy deterministically maps a client IP to the same upstream for
+// as long as the pool's healthy set doesn't change, giving basic session
+// affinity.
+type IPHashPolicy struct{}
+
+// Pick hashes the resolved client IP (from ApplyForwardedHeaders, via the
+// request context), not r.RemoteAddr directly: behind a trusted proxy
+// RemoteAddr is that proxy's own peer address, which is the same for
+// every request and would collapse affinity onto a single upstream.
+func (p *IPHashPolicy) Pick(r *http.Request, healthy []*Upstream) (*Upstream, error) {
+	ip, ok := clientIPFromContext(r.Context())
+	if !ok || ip == "" {
+		ip = remoteIP(r)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(ip))
+	return healthy[h.Sum32()%uint32(len(healthy))], nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestTiming is stashed in a request's context so the transport that
+// actually talks to the upstream can report how long that round trip took
+// back to the access-log middleware wrapping the whole handler chain.
+type requestTiming struct {
+	upstreamStart time.Time
+	upstreamEnd   time.Time
+}
+
+type timingContextKeyType struct{}
+
+var timingContextKey timingContextKeyType
+
+func withTiming(ctx context.Context, t *requestTiming) context.Context {
+	return context.WithValue(ctx, timingContextKey, t)
+}
+
+func timingFromContext(ctx context.Context) (*requestTiming, bool) {
+	t, ok := ctx.Value(timingContextKey).(*requestTiming)
+	return t, ok
+}
+
+// timingRoundTripper wraps a Transport to record how long the upstream
+// round trip for a request took, for AccessLogMiddleware to report.
+type timingRoundTripper struct {
+	next http.RoundTripper
+}
+
+func (t *timingRoundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	timing, ok := timingFromContext(r.Context())
+	if !ok {
+		return t.next.RoundTrip(r)
+	}
+	timing.upstreamStart = time.Now()
+	resp, err := t.next.RoundTrip(r)
+	timing.upstreamEnd = time.Now()
+	return resp, err
+}
+
+// requestState carries the client IP, chosen upstream and generated
+// request ID out of the proxy engines for middleware wrapping them (e.g.
+// AccessLogMiddleware) to read. It has to be a pointer stashed in the
+// request's context rather than a plain context value set by Director,
+// because httputil.ReverseProxy.ServeHTTP clones the request before
+// invoking Director; a context value set on that clone would never be
+// visible on the original *http.Request the wrapping middleware holds.
+// Since context.Context is propagated by reference across Request.Clone,
+// writes through this pointer are visible from both the clone and the
+// original request.
+type requestState struct {
+	mu        sync.Mutex
+	clientIP  string
+	upstream  *Upstream
+	requestID string
+}
+
+type requestStateContextKeyType struct{}
+
+var requestStateContextKey requestStateContextKeyType
+
+// withRequestState attaches a fresh, empty requestState to ctx.
+func withRequestState(ctx context.Context) (context.Context, *requestState) {
+	state := &requestState{}
+	return context.WithValue(ctx, requestStateContextKey, state), state
+}
+
+func requestStateFromContext(ctx context.Context) *requestState {
+	state, _ := ctx.Value(requestStateContextKey).(*requestState)
+	return state
+}
+
+func (s *requestState) setClientIP(ip string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.clientIP = ip
+	s.mu.Unlock()
+}
+
+func (s *requestState) setUpstream(u *Upstream) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.upstream = u
+	s.mu.Unlock()
+}
+
+func (s *requestState) setRequestID(id string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.requestID = id
+	s.mu.Unlock()
+}
+
+func (s *requestState) snapshot() (clientIP string, upstream *Upstream, requestID string) {
+	if s == nil {
+		return "", nil, ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientIP, s.upstream, s.requestID
+}
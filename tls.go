@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig is the on-disk TLS configuration: zero or more static
+// certificate/key pairs selected by SNI, and optionally autocert for
+// Let's Encrypt-issued certificates.
+type TLSConfig struct {
+	Certificates []CertPairConfig `yaml:"certificates"`
+	Autocert     AutocertConfig   `yaml:"autocert"`
+}
+
+// CertPairConfig names a single static certificate/key file pair. The
+// hostnames it serves are taken from the certificate itself (its SAN
+// DNS names and, failing that, its CommonName), not from this config.
+type CertPairConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// AutocertConfig controls automatic certificate issuance via ACME
+// (Let's Encrypt) for a fixed allow-list of hostnames.
+type AutocertConfig struct {
+	Enabled  bool     `yaml:"enabled"`
+	Hosts    []string `yaml:"hosts"`
+	CacheDir string   `yaml:"cacheDir"`
+}
+
+// watchedCertPair tracks a loaded static certificate file so CertManager
+// can detect on-disk changes by polling mtimes, the same way
+// HealthCheckConfig polls upstreams rather than relying on an OS-specific
+// file-watching API.
+type watchedCertPair struct {
+	certFile string
+	keyFile  string
+	modTime  time.Time
+}
+
+// CertManager serves TLS certificates by SNI, reloading static
+// certificate/key pairs from disk when they change and, for hostnames it
+// has no static certificate for, falling back to autocert. It implements
+// tls.Config.GetCertificate.
+type CertManager struct {
+	pairs    []*watchedCertPair
+	autocert *autocert.Manager
+
+	mu     sync.RWMutex
+	byName map[string]*tls.Certificate
+
+	reloading atomic.Bool
+}
+
+// NewCertManager loads every static certificate in cfg and, if
+// cfg.Autocert.Enabled, prepares an autocert.Manager for its host
+// allow-list. It fails fast if neither is configured, since the server
+// would otherwise have no certificate to present.
+func NewCertManager(cfg TLSConfig) (*CertManager, error) {
+	cm := &CertManager{byName: make(map[string]*tls.Certificate)}
+
+	for _, pc := range cfg.Certificates {
+		cert, err := loadCertPair(pc.CertFile, pc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: load %s: %w", pc.CertFile, err)
+		}
+		info, err := os.Stat(pc.CertFile)
+		if err != nil {
+			return nil, fmt.Errorf("tls: stat %s: %w", pc.CertFile, err)
+		}
+		cm.pairs = append(cm.pairs, &watchedCertPair{certFile: pc.CertFile, keyFile: pc.KeyFile, modTime: info.ModTime()})
+		for _, name := range certNames(cert.Leaf) {
+			cm.byName[name] = cert
+		}
+	}
+
+	if cfg.Autocert.Enabled {
+		if len(cfg.Autocert.Hosts) == 0 {
+			return nil, fmt.Errorf("tls: autocert.enabled requires at least one host")
+		}
+		cacheDir := cfg.Autocert.CacheDir
+		if cacheDir == "" {
+			cacheDir = "autocert-cache"
+		}
+		cm.autocert = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Autocert.Hosts...),
+			Cache:      autocert.DirCache(cacheDir),
+		}
+	}
+
+	if len(cm.byName) == 0 && cm.autocert == nil {
+		return nil, fmt.Errorf("tls: no certificates configured (neither static certificates nor autocert)")
+	}
+	return cm, nil
+}
+
+func loadCertPair(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("parse leaf certificate: %w", err)
+	}
+	cert.Leaf = leaf
+	return &cert, nil
+}
+
+func certNames(leaf *x509.Certificate) []string {
+	names := append([]string{}, leaf.DNSNames...)
+	if leaf.Subject.CommonName != "" {
+		names = append(names, leaf.Subject.CommonName)
+	}
+	return names
+}
+
+// TLSConfig builds the *tls.Config servers should use, routing every
+// handshake through cm.GetCertificate. When autocert is configured, it
+// also advertises the "acme-tls/1" ALPN protocol: TLS-ALPN-01, the
+// challenge type autocert uses by default, only completes if the server
+// is willing to negotiate that protocol during the validation handshake.
+func (cm *CertManager) TLSConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: cm.GetCertificate,
+	}
+	if cm.autocert != nil {
+		cfg.NextProtos = []string{"acme-tls/1", "http/1.1", "h2"}
+	}
+	return cfg
+}
+
+// GetCertificate picks a certificate for hello.ServerName: a matching
+// static certificate first, then autocert (which issues or renews one on
+// demand) if configured, then the lone static certificate if there's
+// exactly one and the client sent no SNI at all.
+func (cm *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cm.mu.RLock()
+	cert, ok := cm.byName[hello.ServerName]
+	sole := soleCert(cm.byName)
+	cm.mu.RUnlock()
+
+	if ok {
+		return cert, nil
+	}
+	if cm.autocert != nil {
+		return cm.autocert.GetCertificate(hello)
+	}
+	if hello.ServerName == "" && sole != nil {
+		return sole, nil
+	}
+	return nil, fmt.Errorf("tls: no certificate for SNI %q", hello.ServerName)
+}
+
+func soleCert(byName map[string]*tls.Certificate) *tls.Certificate {
+	var sole *tls.Certificate
+	seen := map[*tls.Certificate]bool{}
+	for _, c := range byName {
+		if !seen[c] {
+			seen[c] = true
+			sole = c
+		}
+	}
+	if len(seen) != 1 {
+		return nil
+	}
+	return sole
+}
+
+// Reload re-reads every static certificate/key pair from disk and swaps
+// them in under a single lock, so in-flight handshakes either see the old
+// or the new set of certificates in full but never a torn mix. It does
+// not touch autocert, which manages its own certificate lifecycle.
+func (cm *CertManager) Reload() error {
+	if len(cm.pairs) == 0 {
+		return nil
+	}
+	cm.reloading.Store(true)
+	defer cm.reloading.Store(false)
+
+	byName := make(map[string]*tls.Certificate, len(cm.pairs))
+	for _, p := range cm.pairs {
+		cert, err := loadCertPair(p.certFile, p.keyFile)
+		if err != nil {
+			return fmt.Errorf("tls: reload %s: %w", p.certFile, err)
+		}
+		for _, name := range certNames(cert.Leaf) {
+			byName[name] = cert
+		}
+	}
+
+	cm.mu.Lock()
+	cm.byName = byName
+	cm.mu.Unlock()
+
+	for _, p := range cm.pairs {
+		if info, err := os.Stat(p.certFile); err == nil {
+			p.modTime = info.ModTime()
+		}
+	}
+	return nil
+}
+
+// Reloading reports whether a Reload is currently in flight, for /readyz
+// to report not-ready rather than risk a client racing a partial reload.
+func (cm *CertManager) Reloading() bool {
+	return cm.reloading.Load()
+}
+
+// Expired reports whether any currently-served static certificate's
+// validity window has passed, for /readyz to report not-ready.
+func (cm *CertManager) Expired() bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	now := time.Now()
+	for _, cert := range cm.byName {
+		if cert.Leaf != nil && now.After(cert.Leaf.NotAfter) {
+			return true
+		}
+	}
+	return false
+}
+
+// WatchForChanges polls the on-disk mtimes of cm's static certificate
+// files every interval and triggers a Reload when any of them changed,
+// stopping when ctx is done. It is a no-op if cm has no static
+// certificates to watch (autocert-only or cache-loaded certs manage their
+// own renewal).
+func (cm *CertManager) WatchForChanges(ctx context.Context, interval time.Duration) {
+	if len(cm.pairs) == 0 {
+		return
+	}
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				cm.checkAndReload()
+			}
+		}
+	}()
+}
+
+func (cm *CertManager) checkAndReload() {
+	changed := false
+	for _, p := range cm.pairs {
+		info, err := os.Stat(p.certFile)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(p.modTime) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	if err := cm.Reload(); err != nil {
+		slog.Error("tls: certificate reload failed", "error", err)
+		return
+	}
+	slog.Info("tls: reloaded certificates from disk")
+}
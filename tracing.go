@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("gorevproxy")
+
+// InitTracing installs an SDK TracerProvider that exports spans as JSON to
+// w, and a W3C tracecontext propagator so traceparent headers round-trip
+// between this proxy and its callers/upstreams. The returned shutdown
+// func should be called (with a bounded context) during graceful shutdown
+// to flush any buffered spans.
+func InitTracing(w io.Writer) (shutdown func(context.Context) error, err error) {
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+// TracingMiddleware starts a server span for each request, extracting any
+// incoming W3C traceparent so the proxy joins the caller's trace, and
+// attaches an httptrace.ClientTrace that records DNS/connect/TLS/TTFB
+// timings for the upstream round trip as span events. net/http's
+// Transport picks up the ClientTrace from the request context
+// automatically, so no further wiring is needed for RoundTrip to use it.
+func TracingMiddleware(next http.Handler) http.Handler {
+	propagator := otel.GetTextMapPropagator()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+r.URL.Path, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+			DNSStart:             func(httptrace.DNSStartInfo) { span.AddEvent("dns_start") },
+			DNSDone:              func(httptrace.DNSDoneInfo) { span.AddEvent("dns_done") },
+			ConnectStart:         func(string, string) { span.AddEvent("connect_start") },
+			ConnectDone:          func(string, string, error) { span.AddEvent("connect_done") },
+			TLSHandshakeStart:    func() { span.AddEvent("tls_handshake_start") },
+			TLSHandshakeDone:     func(tls.ConnectionState, error) { span.AddEvent("tls_handshake_done") },
+			GotFirstResponseByte: func() { span.AddEvent("ttfb") },
+		})
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// injectTraceparent writes the current span context from r's context onto
+// r's own headers, so the upstream request carries a W3C traceparent.
+func injectTraceparent(r *http.Request) {
+	otel.GetTextMapPropagator().Inject(r.Context(), propagation.HeaderCarrier(r.Header))
+}
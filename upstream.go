@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNoHealthyUpstreams is returned by Pool.Pick when every upstream in the
+// pool is currently marked unhealthy.
+var ErrNoHealthyUpstreams = errors.New("upstream: no healthy upstreams available")
+
+// Upstream is a single backend URL tracked by a Pool. Its health and
+// in-flight connection count are updated concurrently by the health
+// checker and the proxy handler, so all mutable state is accessed
+// atomically.
+type Upstream struct {
+	URL    *url.URL
+	Weight int
+
+	healthy     atomic.Bool
+	activeConns int64
+}
+
+// NewUpstream builds an Upstream from a raw URL, defaulting Weight to 1 and
+// starting out healthy so it's eligible for selection before the first
+// health probe runs.
+func NewUpstream(raw string, weight int) (*Upstream, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if weight <= 0 {
+		weight = 1
+	}
+	up := &Upstream{URL: u, Weight: weight}
+	up.healthy.Store(true)
+	return up, nil
+}
+
+// Healthy reports whether the last health probe succeeded.
+func (u *Upstream) Healthy() bool { return u.healthy.Load() }
+
+// SetHealthy updates the upstream's health state.
+func (u *Upstream) SetHealthy(h bool) { u.healthy.Store(h) }
+
+// Conns returns the number of requests currently in flight to this upstream.
+func (u *Upstream) Conns() int64 { return atomic.LoadInt64(&u.activeConns) }
+
+func (u *Upstream) incConns() { atomic.AddInt64(&u.activeConns, 1) }
+func (u *Upstream) decConns() { atomic.AddInt64(&u.activeConns, -1) }
+
+// Pool is a set of upstreams behind a selection Policy. It is safe for
+// concurrent use by the proxy handler and the background health checker.
+type Pool struct {
+	mu        sync.RWMutex
+	upstreams []*Upstream
+	policy    Policy
+}
+
+// NewPool builds a Pool over the given upstreams using policy for selection.
+func NewPool(upstreams []*Upstream, policy Policy) *Pool {
+	return &Pool{upstreams: upstreams, policy: policy}
+}
+
+// All returns every upstream in the pool, healthy or not.
+func (p *Pool) All() []*Upstream {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	out := make([]*Upstream, len(p.upstreams))
+	copy(out, p.upstreams)
+	return out
+}
+
+// Healthy returns the subset of upstreams currently marked healthy.
+func (p *Pool) Healthy() []*Upstream {
+	all := p.All()
+	healthy := make([]*Upstream, 0, len(all))
+	for _, u := range all {
+		if u.Healthy() {
+			healthy = append(healthy, u)
+		}
+	}
+	return healthy
+}
+
+// HealthyCount returns the number of upstreams currently marked healthy.
+func (p *Pool) HealthyCount() int {
+	n := 0
+	for _, u := range p.All() {
+		if u.Healthy() {
+			n++
+		}
+	}
+	return n
+}
+
+// Pick selects an upstream for r using the pool's policy, considering only
+// healthy upstreams. It returns ErrNoHealthyUpstreams if none are available.
+func (p *Pool) Pick(r *http.Request) (*Upstream, error) {
+	healthy := p.Healthy()
+	if len(healthy) == 0 {
+		return nil, ErrNoHealthyUpstreams
+	}
+	return p.policy.Pick(r, healthy)
+}
+
+// MarkUnhealthy removes u from rotation until the next successful probe (or
+// an explicit MarkHealthy call) puts it back.
+func (p *Pool) MarkUnhealthy(u *Upstream) { u.SetHealthy(false) }
+
+// MarkHealthy restores u to rotation.
+func (p *Pool) MarkHealthy(u *Upstream) { u.SetHealthy(true) }